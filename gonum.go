@@ -2,16 +2,23 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"embed"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/format"
 	"go/token"
 	"go/types"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -20,9 +27,16 @@ import (
 	"golang.org/x/tools/go/packages"
 )
 
+//go:embed templates/*/*.tmpl
+var defaultTemplatesFS embed.FS
+
 var (
-	typeNames = flag.String("types", "", "comma-separated list of type names")
-	output    = flag.String("output", "", "output file name; default <src dir>/enum.go")
+	typeNames    = flag.String("types", "", "comma-separated list of type names")
+	output       = flag.String("output", "", "output file name; default <src dir>/enum.go")
+	trimPrefix   = flag.String("trimprefix", "", "trim the `prefix` from the generated display names of const-declared enums")
+	fastJSON     = flag.Bool("fastjson", false, "emit allocation-light MarshalJSON/UnmarshalJSON bodies instead of calling encoding/json")
+	openapiOut   = flag.String("openapi", "", "write an OpenAPI enum sidecar (x-enum-varnames/x-enum-descriptions) to this `file`")
+	templatesDir = flag.String("templates", "", "`directory` of *.tmpl files overriding or extending the embedded default templates")
 )
 
 func Usage() {
@@ -60,23 +74,60 @@ func main() {
 		dir = args[0]
 	}
 
+	g.trimPrefix = *trimPrefix
+	g.fastJSON = *fastJSON
+	g.templatesDir = *templatesDir
+	if *openapiOut != "" {
+		g.openapi = make(map[string]openapiSchema)
+	}
+	g.initBaseImports()
 	g.parsePackage(args)
 
-	// Print the header and package clause.
-	g.Printf("// Code generated by \"gonum %s\"; DO NOT EDIT.\n", strings.Join(os.Args[1:], " "))
-	g.Printf("\n")
-	g.Printf("package %s", g.pkg.name)
-	g.Printf("\n")
-	g.Printf("import \"encoding/json\"\n")
-	g.Printf("import \"errors\"\n")
-	g.Printf("import \"fmt\"\n")
-	g.Printf("\n")
-
-	// Run generate for each type.
+	// Run generate for each type. This also loads every template set the
+	// types need, so it must run before the header is printed: an -templates
+	// role can declare its own extra imports (see TemplateSet.imports), and
+	// those only become known once the templates are loaded.
 	for _, typeName := range typs {
 		g.generate(typeName)
 	}
 
+	// Print the header and package clause.
+	g.PrintHeader("// Code generated by \"gonum %s\"; DO NOT EDIT.\n", strings.Join(os.Args[1:], " "))
+	g.PrintHeader("\n")
+	g.PrintHeader("package %s", g.pkg.name)
+	g.PrintHeader("\n")
+	if g.fastJSON {
+		g.PrintHeader("import \"bytes\"\n")
+		g.PrintHeader("import \"strconv\"\n")
+	} else {
+		g.PrintHeader("import \"encoding/json\"\n")
+	}
+	g.PrintHeader("import \"errors\"\n")
+	g.PrintHeader("import \"fmt\"\n")
+	for _, imp := range g.extraImports {
+		g.PrintHeader("import %q\n", imp)
+	}
+	g.PrintHeader("\n")
+	// minSliceBytes and UseNumericJSON are declared once per package, not
+	// once per generated file: the standard pattern is one //go:generate
+	// gonum -output=... directive per type in the same package/dir, and a
+	// second invocation's package parse already picks up whatever the first
+	// invocation's output file declared (packages.Load sees every .go file
+	// in the dir, generated or not), so re-declaring here would redeclare.
+	if g.fastJSON && !g.packageDeclares("minSliceBytes") {
+		// minSliceBytes sizes the buffer each generated MarshalJSON reuses;
+		// enum tokens are short, so one small allocation up front avoids the
+		// buffer ever having to grow.
+		g.PrintHeader("const minSliceBytes = 64\n\n")
+	}
+	if !g.packageDeclares("UseNumericJSON") {
+		// UseNumericJSON toggles proto-JSON's numeric form (the enum's Number)
+		// instead of its display name in every generated MarshalJSON, matching
+		// protobuf's EmitDefaults/OrigName-style package-level switches. The
+		// generated UnmarshalJSON does not read it: it accepts both forms.
+		g.PrintHeader("var UseNumericJSON bool\n\n")
+	}
+
 	// Format the output.
 	src := g.format()
 
@@ -89,6 +140,17 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	// Write the OpenAPI sidecar, if requested.
+	if *openapiOut != "" {
+		data, err := json.MarshalIndent(g.openapi, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(*openapiOut, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
 
 // generate produces the enum code for the named type.
@@ -96,13 +158,23 @@ func (g *Generator) generate(typeName string) {
 	var enums []enum
 	for _, file := range g.pkg.files {
 		file.enums = nil
+		file.constEnum = nil
 		file.typeName = typeName
 		ast.Inspect(file.file, file.genDecl)
+		if file.constEnum != nil {
+			file.enums = append(file.enums, *file.constEnum)
+		}
 		if len(file.enums) > 0 {
 			enums = append(enums, file.enums...)
 		}
 	}
 
+	// A const-declared enum's iota block is ordinary Go, so it's free to be
+	// split across multiple const(...) blocks within a file or across files
+	// in the same package; merge those split entries into a single enum
+	// before rendering so the template only runs once per type.
+	enums = mergeSplitEnums(enums)
+
 	if len(enums) == 0 {
 		log.Fatalf("no values defined for type %s", typeName)
 	}
@@ -110,44 +182,298 @@ func (g *Generator) generate(typeName string) {
 	for _, enum := range enums {
 		var fields []fieldModel
 		for _, field := range enum.elements {
+			name := field.name
+			if enum.kind == enumKindConst && g.trimPrefix != "" {
+				name = strings.TrimPrefix(name, g.trimPrefix)
+			}
 
 			fields = append(fields, fieldModel{
-				Key:         field.name,
+				Key:         name,
 				Value:       field.value,
 				Description: field.description,
+				IntValue:    field.intValue,
 			})
 		}
 
 		instanceModel := model{
-			InstanceVariable: fmt.Sprintf("%sInstance", lowerFirstChar(enum.newName)),
-			OriginalType:     enum.originalName,
-			NewType:          enum.newName,
-			Fields:           fields,
+			InstanceVariable:  fmt.Sprintf("%sInstance", lowerFirstChar(enum.newName)),
+			OriginalType:      enum.originalName,
+			NewType:           enum.newName,
+			Fields:            fields,
+			DescriptorLiteral: fmt.Sprintf("%#v", gzippedEnumDescriptor(enum.newName, fields)),
+		}
+
+		if g.openapi != nil {
+			schema := openapiSchema{Type: "string"}
+			for _, field := range fields {
+				schema.Enum = append(schema.Enum, field.Key)
+				schema.XEnumVarNames = append(schema.XEnumVarNames, field.Value)
+				schema.XEnumDescriptions = append(schema.XEnumDescriptions, field.Description)
+			}
+			g.openapi[enum.newName] = schema
+		}
+
+		ts, err := g.templateSetFor(enum.kind)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Core roles always run, in a fixed order, since later roles
+		// (marshal/unmarshal) reference identifiers the earlier ones define.
+		for _, role := range coreTemplateRoles {
+			g.renderRole(ts, role, instanceModel)
+		}
+
+		// Any remaining roles are opt-in extras dropped into -templates by
+		// the user (e.g. sql, text, yaml, graphql); run them in a stable
+		// order so output doesn't reshuffle between generate runs.
+		var extra []string
+		for role := range ts.templates {
+			if !coreTemplateRoleSet[role] {
+				extra = append(extra, role)
+			}
+		}
+		sort.Strings(extra)
+		for _, role := range extra {
+			g.renderRole(ts, role, instanceModel)
+		}
+	}
+}
+
+// coreTemplateRoles are the roles every enum kind must provide, rendered in
+// this order for every generated type.
+var coreTemplateRoles = []string{"constructor", "values", "marshal", "unmarshal"}
+
+var coreTemplateRoleSet = func() map[string]bool {
+	set := make(map[string]bool, len(coreTemplateRoles))
+	for _, role := range coreTemplateRoles {
+		set[role] = true
+	}
+	return set
+}()
+
+// templateFuncMap is available to every template, core or user-supplied.
+var templateFuncMap = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"camel": camelCase,
+	"snake": snakeCase,
+	"quote": strconv.Quote,
+}
+
+// camelCase converts a snake_case or space-separated string to camelCase,
+// e.g. for template authors building extra identifiers from a field's Key.
+func camelCase(s string) string {
+	var b strings.Builder
+	upperNext := false
+	for i, r := range s {
+		switch {
+		case r == '_' || r == ' ' || r == '-':
+			upperNext = true
+		case upperNext:
+			b.WriteRune(unicode.ToUpper(r))
+			upperNext = false
+		case i == 0:
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// snakeCase converts a camelCase or PascalCase string to snake_case.
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			b.WriteRune(r)
 		}
+	}
+	return b.String()
+}
+
+// TemplateSet holds the role -> template mapping used to render one enum
+// kind (struct or const), after embedded defaults have been overlaid with
+// any user-supplied -templates files.
+type TemplateSet struct {
+	templates map[string]*template.Template
+	imports   []string // extra imports declared by roles in this set, see importDirectiveRe.
+}
+
+// templateSetFor returns the TemplateSet for kind ("struct" or "const"),
+// loading and caching it on first use.
+func (g *Generator) templateSetFor(kind string) (*TemplateSet, error) {
+	if g.templateSets == nil {
+		g.templateSets = make(map[string]*TemplateSet)
+	}
+	if ts, ok := g.templateSets[kind]; ok {
+		return ts, nil
+	}
+
+	ts, err := loadTemplateSet(kind, g.fastJSON, g.templatesDir)
+	if err != nil {
+		return nil, err
+	}
+	g.templateSets[kind] = ts
+	g.addExtraImports(ts.imports)
+	return ts, nil
+}
+
+// importDirectiveRe matches a `{{/* gonum:import "path" */}}` directive: a
+// user-supplied -templates role (e.g. sql.tmpl using database/sql/driver)
+// uses it to declare an import the generated file's header must carry,
+// since the header is emitted once per file rather than per role.
+var importDirectiveRe = regexp.MustCompile(`{{/\*\s*gonum:import\s+"([^"]+)"\s*\*/}}`)
+
+// initBaseImports seeds the dedupe set with the imports main always writes
+// itself, so a role's gonum:import directive naming one of them is a no-op
+// instead of a duplicate import.
+func (g *Generator) initBaseImports() {
+	g.extraImportSet = map[string]bool{"errors": true, "fmt": true}
+	if g.fastJSON {
+		g.extraImportSet["bytes"] = true
+		g.extraImportSet["strconv"] = true
+	} else {
+		g.extraImportSet["encoding/json"] = true
+	}
+}
 
-		g.render(instanceTemplate, instanceModel)
+// addExtraImports records imports declared by -templates roles, in first-seen
+// order, skipping ones already covered by the base import set.
+func (g *Generator) addExtraImports(imports []string) {
+	for _, imp := range imports {
+		if g.extraImportSet[imp] {
+			continue
+		}
+		g.extraImportSet[imp] = true
+		g.extraImports = append(g.extraImports, imp)
 	}
 }
 
-func (g *Generator) render(tmpl string, model interface{}) {
-	t, err := template.New(tmpl).Parse(tmpl)
+// loadTemplateSet builds the role -> template mapping for kind by reading
+// the embedded defaults under templates/<kind>/*.tmpl, preferring the
+// "_fast" variant of marshal/unmarshal when fastJSON is set, then overlaying
+// any *.tmpl files found in userDir (role name = file name minus extension).
+func loadTemplateSet(kind string, fastJSON bool, userDir string) (*TemplateSet, error) {
+	ts := &TemplateSet{templates: make(map[string]*template.Template)}
+
+	entries, err := fs.ReadDir(defaultTemplatesFS, filepath.Join("templates", kind))
 	if err != nil {
-		log.Fatal("instance template parse: ", err)
+		return nil, fmt.Errorf("loading default templates for %s: %w", kind, err)
+	}
+
+	// Only marshal/unmarshal ship a "_fast" sibling; roles like constructor
+	// and values have a single, fastjson-agnostic file and must always be
+	// included regardless of -fastjson.
+	hasFastVariant := make(map[string]bool)
+	for _, entry := range entries {
+		role := strings.TrimSuffix(entry.Name(), ".tmpl")
+		if strings.HasSuffix(role, "_fast") {
+			hasFastVariant[strings.TrimSuffix(role, "_fast")] = true
+		}
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		role := strings.TrimSuffix(name, ".tmpl")
+		fast := strings.HasSuffix(role, "_fast")
+		role = strings.TrimSuffix(role, "_fast")
+
+		if hasFastVariant[role] && fast != fastJSON {
+			// Skip the fast variant unless -fastjson is set, and skip the
+			// plain variant once the fast one is in play.
+			continue
+		}
+
+		content, err := defaultTemplatesFS.ReadFile(filepath.Join("templates", kind, name))
+		if err != nil {
+			return nil, fmt.Errorf("reading default template %s: %w", name, err)
+		}
+		if err := ts.parse(role, string(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if userDir == "" {
+		return ts, nil
 	}
 
-	err = t.Execute(&g.buf, model)
+	files, err := filepath.Glob(filepath.Join(userDir, "*.tmpl"))
 	if err != nil {
-		log.Fatal("Execute: ", err)
+		return nil, fmt.Errorf("globbing -templates %s: %w", userDir, err)
+	}
+	for _, file := range files {
+		role := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading -templates file %s: %w", file, err)
+		}
+		if err := ts.parse(role, string(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	return ts, nil
+}
+
+func (ts *TemplateSet) parse(role, content string) error {
+	for _, match := range importDirectiveRe.FindAllStringSubmatch(content, -1) {
+		ts.imports = append(ts.imports, match[1])
+	}
+
+	t, err := template.New(role).Funcs(templateFuncMap).Parse(content)
+	if err != nil {
+		return fmt.Errorf("parsing %s template: %w", role, err)
+	}
+	ts.templates[role] = t
+	return nil
+}
+
+// renderRole executes the template registered for role against model,
+// appending its output to g.buf. Core roles are always present via the
+// embedded defaults, so a missing core role is a bug, not a legitimate
+// no-op; optional roles simply aren't rendered if the user never supplied
+// a template file for them.
+func (g *Generator) renderRole(ts *TemplateSet, role string, model interface{}) {
+	t, ok := ts.templates[role]
+	if !ok {
+		if coreTemplateRoleSet[role] {
+			log.Fatalf("no template registered for required role %q", role)
+		}
 		return
 	}
+
+	if err := t.Execute(&g.buf, model); err != nil {
+		log.Fatal("Execute: ", err)
+	}
 }
 
 func (f *File) genDecl(node ast.Node) bool {
 	decl, ok := node.(*ast.GenDecl)
-	if !ok || decl.Tok != token.TYPE {
+	if !ok {
+		return true
+	}
+
+	switch decl.Tok {
+	case token.TYPE:
+		f.genStructEnum(decl)
+	case token.CONST:
+		f.genConstEnum(decl)
+	default:
 		return true
 	}
+	return false
+}
 
+// genStructEnum handles the struct-with-`enum`-tag input: a type declaration
+// whose fields are tagged `enum:"Name,description"`.
+func (f *File) genStructEnum(decl *ast.GenDecl) {
 	for _, spec := range decl.Specs {
 		vspec := spec.(*ast.TypeSpec)
 		if vspec.Name.Name != f.typeName {
@@ -156,6 +482,7 @@ func (f *File) genDecl(node ast.Node) bool {
 
 		if structType, ok := vspec.Type.(*ast.StructType); ok {
 			var e *enum
+			var nextNumber int64
 			if structType.Fields != nil {
 				for _, field := range structType.Fields.List {
 					if field.Tag != nil && strings.HasPrefix(field.Tag.Value, "`enum:") {
@@ -163,18 +490,24 @@ func (f *File) genDecl(node ast.Node) bool {
 							e = &enum{
 								originalName: vspec.Name.Name,
 								newName:      strings.Replace(vspec.Name.Name, "Enum", "", -1),
+								kind:         enumKindStruct,
 								elements:     []enumElement{},
 							}
 						}
 						if len(field.Names) > 0 {
-							name, description := parseEnumStructTag(field.Tag.Value)
+							name, description, number, hasNumber := parseEnumStructTag(field.Tag.Value)
 							if name == "-" {
 								name = field.Names[0].Name
 							}
+							if !hasNumber {
+								number = nextNumber
+							}
+							nextNumber = number + 1
 							e.elements = append(e.elements, enumElement{
 								value:       field.Names[0].Name,
 								name:        name,
 								description: description,
+								intValue:    number,
 							})
 						}
 					}
@@ -186,21 +519,113 @@ func (f *File) genDecl(node ast.Node) bool {
 			}
 		}
 	}
-	return false
 }
 
-func parseEnumStructTag(content string) (string, string) {
+// genConstEnum handles the classic Go enum pattern: a named integer type plus
+// a const (...) block using iota. Each constant whose type resolves to
+// f.typeName becomes an enumElement, keyed by its own identifier, with its
+// integer value and trailing line comment (if any) as the description.
+//
+// It's ordinary for the constants of one type to be spread across more than
+// one const(...) block in a file, so f.constEnum is accumulated across calls
+// for the same file rather than built fresh each time; the caller collects
+// it into f.enums once the whole file has been inspected.
+func (f *File) genConstEnum(decl *ast.GenDecl) {
+	for _, spec := range decl.Specs {
+		vspec, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+
+		for _, name := range vspec.Names {
+			obj, ok := f.pkg.defs[name]
+			if !ok {
+				continue
+			}
+			con, ok := obj.(*types.Const)
+			if !ok {
+				continue
+			}
+			named, ok := con.Type().(*types.Named)
+			if !ok || named.Obj().Name() != f.typeName {
+				continue
+			}
+			if con.Val().Kind() != constant.Int {
+				continue
+			}
+
+			if f.constEnum == nil {
+				f.constEnum = &enum{
+					originalName: f.typeName,
+					newName:      f.typeName,
+					kind:         enumKindConst,
+					elements:     []enumElement{},
+				}
+			}
+
+			var description string
+			if vspec.Comment != nil {
+				description = strings.TrimSpace(vspec.Comment.Text())
+			}
+
+			intValue, _ := constant.Int64Val(con.Val())
+			f.constEnum.elements = append(f.constEnum.elements, enumElement{
+				value:       name.Name,
+				name:        name.Name,
+				description: description,
+				intValue:    intValue,
+			})
+		}
+	}
+}
+
+// mergeSplitEnums combines enum entries sharing a kind and newName into one,
+// concatenating their elements in the order encountered. const-declared
+// enums may be split across const(...) blocks within a file (handled by
+// file.constEnum) or across files in the package, so entries for the same
+// type can still arrive here more than once.
+func mergeSplitEnums(enums []enum) []enum {
+	var order []string
+	merged := make(map[string]*enum, len(enums))
+	for _, e := range enums {
+		key := e.kind + "|" + e.newName
+		if existing, ok := merged[key]; ok {
+			existing.elements = append(existing.elements, e.elements...)
+			continue
+		}
+		copied := e
+		merged[key] = &copied
+		order = append(order, key)
+	}
+
+	out := make([]enum, 0, len(order))
+	for _, key := range order {
+		out = append(out, *merged[key])
+	}
+	return out
+}
+
+// parseEnumStructTag parses an `enum:"Name,description,number"` tag. The
+// trailing number is the protobuf-compatible numeric value for the element;
+// when it is absent, hasNumber is false and the caller auto-assigns one by
+// declaration order.
+func parseEnumStructTag(content string) (name, description string, number int64, hasNumber bool) {
 	if value, ok := parseStructTag(content, "`enum"); ok {
 		splits := strings.Split(value, ",")
-		name := splits[0]
-		var description string
+		name = splits[0]
 		if len(splits) > 1 {
 			description = splits[1]
 		}
-		return name, description
+		if len(splits) > 2 {
+			n, err := strconv.ParseInt(strings.TrimSpace(splits[2]), 10, 32)
+			if err == nil {
+				number, hasNumber = n, true
+			}
+		}
+		return name, description, number, hasNumber
 	}
 	log.Fatal("enum struct tag did not contain name")
-	return "", ""
+	return "", "", 0, false
 }
 
 func parseStructTag(tag string, key string) (value string, ok bool) {
@@ -271,14 +696,64 @@ func (g *Generator) parsePackage(patterns []string) {
 	g.addPackage(pkgs[0])
 }
 
+// packageDeclares reports whether the package being generated already has a
+// top-level var or const named name, declared in any of its files (including
+// a previously generated enum.go/-output file still on disk from an earlier
+// gonum invocation). Used to avoid redeclaring a package-level symbol that's
+// meant to exist once per package, not once per gonum invocation.
+func (g *Generator) packageDeclares(name string) bool {
+	for _, file := range g.pkg.files {
+		for _, decl := range file.file.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || (gd.Tok != token.VAR && gd.Tok != token.CONST) {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vspec, ok := spec.(*ast.ValueSpec)
+				if !ok {
+					continue
+				}
+				for _, n := range vspec.Names {
+					if n.Name == name {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
 // Generator holds the state of the analysis. Primarily used to buffer
 // the output for format.Source.
 type Generator struct {
-	buf bytes.Buffer // Accumulated output.
-	pkg *Package     // Package we are scanning.
+	header bytes.Buffer // Package clause, imports and package-level vars.
+	buf    bytes.Buffer // Accumulated per-type output.
+	pkg    *Package     // Package we are scanning.
 
-	trimPrefix  string
-	lineComment bool
+	trimPrefix   string
+	lineComment  bool
+	fastJSON     bool
+	openapi      map[string]openapiSchema
+	templatesDir string
+
+	templateSets map[string]*TemplateSet
+
+	// extraImports are paths declared by -templates roles via a gonum:import
+	// directive, in first-seen order; extraImportSet dedupes them against
+	// each other and against the imports main always writes itself.
+	extraImports   []string
+	extraImportSet map[string]bool
+}
+
+// openapiSchema is one entry of the -openapi sidecar, describing a generated
+// enum the way swaggo-style tooling expects a string schema with its Go
+// identifiers and descriptions alongside the display values.
+type openapiSchema struct {
+	Type              string   `json:"type"`
+	Enum              []string `json:"enum"`
+	XEnumVarNames     []string `json:"x-enum-varnames"`
+	XEnumDescriptions []string `json:"x-enum-descriptions"`
 }
 
 func (g *Generator) Printf(format string, args ...interface{}) {
@@ -288,6 +763,15 @@ func (g *Generator) Printf(format string, args ...interface{}) {
 	}
 }
 
+// PrintHeader writes to the package clause/imports/package-vars section that
+// is prepended to g.buf's per-type output at format time.
+func (g *Generator) PrintHeader(format string, args ...interface{}) {
+	_, err := fmt.Fprintf(&g.header, format, args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func isDirectory(name string) bool {
 	info, err := os.Stat(name)
 	if err != nil {
@@ -303,15 +787,23 @@ type Package struct {
 }
 
 type File struct {
-	pkg      *Package  // Package to which this file belongs.
-	file     *ast.File // Parsed AST.
-	typeName string    // Name of the constant type.
-	enums    []enum
+	pkg       *Package  // Package to which this file belongs.
+	file      *ast.File // Parsed AST.
+	typeName  string    // Name of the constant type.
+	enums     []enum
+	constEnum *enum // accumulates genConstEnum's elements across const(...) blocks in this file.
 }
 
+// enum kinds, used to pick which template renders a given enum.
+const (
+	enumKindStruct = "struct"
+	enumKindConst  = "const"
+)
+
 type enum struct {
 	originalName string
 	newName      string
+	kind         string
 	elements     []enumElement
 }
 
@@ -319,17 +811,19 @@ type enumElement struct {
 	value       string
 	name        string
 	description string
+	intValue    int64
 }
 
 // format returns the gofmt-ed contents of the Generator's buffer.
 func (g *Generator) format() []byte {
-	src, err := format.Source(g.buf.Bytes())
+	full := append(append([]byte(nil), g.header.Bytes()...), g.buf.Bytes()...)
+	src, err := format.Source(full)
 	if err != nil {
 		// Should never happen, but can arise when developing this code.
 		// The user can compile the output to see the error.
 		log.Printf("warning: internal error: invalid Go generated: %s", err)
 		log.Printf("warning: compile the package to analyze the error")
-		return g.buf.Bytes()
+		return full
 	}
 	return src
 }
@@ -356,136 +850,52 @@ func lowerFirstChar(in string) string {
 	return string(v)
 }
 
-type model struct {
-	InstanceVariable string
-	OriginalType     string
-	NewType          string
-	Fields           []fieldModel
+// enumDescriptorPayload is the descriptor embedded, gzip-compressed, in each
+// generated EnumDescriptor: the enum's name, display values and numbers,
+// encoded as JSON. It is not a serialized FileDescriptorProto; only the
+// EnumDescriptor method signature matches what protoc-gen-go emits.
+type enumDescriptorPayload struct {
+	Name    string   `json:"name"`
+	Values  []string `json:"values"`
+	Numbers []int32  `json:"numbers"`
 }
 
-type fieldModel struct {
-	Key         string
-	Value       string
-	Description string
-}
-
-const instanceTemplate = `
-type {{.InstanceVariable}}JsonDescriptionModel struct {
-	Name string ` + "`json:" + `"name"` + "`" + `
-	Description string ` + "`json:" + `"description"` + "`" + `
-}
-
-var {{.InstanceVariable}} = {{.OriginalType}}{
-{{- range .Fields}}
-    {{.Value}}: "{{.Key}}",
-{{- end}}
-}
-
-// {{.NewType}} is the enum that instances should be created from
-type {{.NewType}} struct {
-	name  string
-	value string
-	description string
-}
-
-// Enum instances
-{{- range $e := .Fields}}
-var {{.Value}} = {{$.NewType}}{name: "{{.Key}}", value: "{{.Value}}", description: "{{.Description}}"}
-{{- end}}
-
-// New{{.NewType}} generates a new {{.NewType}} from the given display value (name)
-func New{{.NewType}}(value string) ({{.NewType}}, error) {
-	switch value {
-{{- range $e := .Fields}}
-	case "{{.Key}}":
-		return {{.Value}}, nil
-{{- end}}
-	default:
-		return {{.NewType}}{}, errors.New(
-			fmt.Sprintf("'%s' is not a valid value for type", value))
-	}
-}
-
-// Name returns the enum display value
-func (g {{.NewType}}) Name() string {
-	switch g {
-{{- range $e := .Fields}}
-	case {{$e.Value}}:
-		return {{$e.Value}}.name
-{{- end}}
-	default:
-		panic("Could not map enum")
+// gzippedEnumDescriptor builds and gzip-compresses the descriptor payload for
+// an enum at generate time, ready to be embedded as a Go byte slice literal.
+func gzippedEnumDescriptor(typeName string, fields []fieldModel) []byte {
+	payload := enumDescriptorPayload{Name: typeName}
+	for _, field := range fields {
+		payload.Values = append(payload.Values, field.Key)
+		payload.Numbers = append(payload.Numbers, int32(field.IntValue))
 	}
-}
 
-// String returns the enum display value and is an alias of Name to implement the Stringer interface
-func (g {{.NewType}}) String() string {
-	return g.Name()
-}
-
-// Error returns the enum name and implements the Error interface
-func (g {{.NewType}}) Error() string {
-	return g.Name()
-}
-
-// Description returns the enum description if present. If no description is defined an empty string is returned
-func (g {{.NewType}}) Description() string {
-switch g {
-{{- range $e := .Fields}}
-	case {{$e.Value}}:
-		return "{{$e.Description}}"
-{{- end}}
-	default:
-		panic("Could not map enum description")
+	descJSON, err := json.Marshal(payload)
+	if err != nil {
+		log.Fatal(err)
 	}
-}
 
-// {{.NewType}}Names returns the displays values of all enum instances as a slice
-func {{.NewType}}Names() []string {
-	return []string{
-	{{- range $e := .Fields}}
-		"{{.Key}}",
-	{{- end}}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(descJSON); err != nil {
+		log.Fatal(err)
 	}
-}
-
-// {{.NewType}}Values returns all enum instances as a slice
-func {{.NewType}}Values() []{{.NewType}} {
-	return []{{.NewType}}{
-	{{- range $e := .Fields}}
-		{{.Value}},
-	{{- end}}
+	if err := zw.Close(); err != nil {
+		log.Fatal(err)
 	}
+	return buf.Bytes()
 }
 
-// MarshalJSON provides json serialization support by implementing the Marshaler interface
-func (g {{.NewType}}) MarshalJSON() ([]byte, error) {
-	if g.Description() != "" {
-		m := {{.InstanceVariable}}JsonDescriptionModel {
-			Name: g.Name(),
-			Description: g.Description(),
-		}
-		return json.Marshal(m)
-	}
-	return json.Marshal(g.Name())
+type model struct {
+	InstanceVariable  string
+	OriginalType      string
+	NewType           string
+	Fields            []fieldModel
+	DescriptorLiteral string
 }
 
-// UnmarshalJSON provides json deserialization support by implementing the Unmarshaler interface
-func (g *{{.NewType}}) UnmarshalJSON(b []byte) error {
-	var v string
-	err := json.Unmarshal(b, &v)
-	if err != nil {
-		return err
-	}
-
-	instance, createErr := New{{.NewType}}(v)
-	if createErr != nil {
-		return createErr
-	}
-
-	g.name = instance.name
-	g.value = instance.value
-
-	return nil
+type fieldModel struct {
+	Key         string
+	Value       string
+	Description string
+	IntValue    int64
 }
-`